@@ -0,0 +1,300 @@
+package b64filter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func decodeOutput(t *testing.T, out []byte) [][]byte {
+	t.Helper()
+	if len(out) == 0 {
+		return nil
+	}
+	var got [][]byte
+	for _, line := range bytes.Split(bytes.TrimRight(out, "\n"), []byte("\n")) {
+		b, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			t.Fatalf("decoding output line: %v", err)
+		}
+		got = append(got, b)
+	}
+	return got
+}
+
+// runFiltered encodes input as base64 documents, runs f over them through
+// an io.Pipe, and decodes the result.
+func runFiltered(t *testing.T, f *Filter, input [][]byte) [][]byte {
+	t.Helper()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, doc := range input {
+			fmt.Fprintln(pw, base64.StdEncoding.EncodeToString(doc))
+		}
+		pw.Close()
+	}()
+
+	var out bytes.Buffer
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.Run(context.Background(), pr, &out) }()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not finish")
+	}
+
+	return decodeOutput(t, out.Bytes())
+}
+
+// TestRunRoundTrip checks that a trivial filter run through a pair of
+// io.Pipes returns each document unchanged.
+func TestRunRoundTrip(t *testing.T) {
+	f := New([]string{"cat"})
+	input := [][]byte{[]byte("hello"), []byte("world"), []byte("multi\nline")}
+
+	got := runFiltered(t, f, input)
+	if len(got) != len(input) {
+		t.Fatalf("got %d documents, want %d", len(got), len(input))
+	}
+	for i, doc := range input {
+		if !bytes.Equal(got[i], doc) {
+			t.Errorf("document %d: got %q, want %q", i, got[i], doc)
+		}
+	}
+}
+
+// TestRunStallingFilterBackpressure exercises a filter that stalls for a
+// couple of seconds before emitting any output, with a small Buffer, and
+// checks that Run completes without deadlocking.
+func TestRunStallingFilterBackpressure(t *testing.T) {
+	f := New([]string{"sh", "-c", "sleep 2; cat"})
+	f.Buffer = 1
+
+	input := [][]byte{[]byte("hello"), []byte("world"), []byte("multi\nline")}
+	got := runFiltered(t, f, input)
+	if len(got) != len(input) {
+		t.Fatalf("got %d documents, want %d", len(got), len(input))
+	}
+	for i, doc := range input {
+		if !bytes.Equal(got[i], doc) {
+			t.Errorf("document %d: got %q, want %q", i, got[i], doc)
+		}
+	}
+}
+
+// TestRunWorkerRestartRecoversCrash feeds documents to a filter that
+// crashes without producing any output the first time it's run, and
+// checks that the worker is restarted and the in-flight documents are
+// re-fed and recovered. Multi-line documents are used so the short read
+// left behind by the crash is unambiguous.
+func TestRunWorkerRestartRecoversCrash(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran-once")
+	script := fmt.Sprintf(`if [ -f %q ]; then cat; else touch %q; exit 1; fi`, marker, marker)
+	f := New([]string{"sh", "-c", script})
+	f.RestartLimit = 1
+
+	input := [][]byte{[]byte("hello\nthere"), []byte("world\nagain")}
+	got := runFiltered(t, f, input)
+	if len(got) != len(input) {
+		t.Fatalf("got %d documents, want %d", len(got), len(input))
+	}
+	for i, doc := range input {
+		if !bytes.Equal(got[i], doc) {
+			t.Errorf("document %d: got %q, want %q", i, got[i], doc)
+		}
+	}
+}
+
+// TestRunOnErrorSkipDropsUnrecoverableDocuments checks that once a
+// worker has exhausted its restarts (here, RestartLimit=0 means none are
+// allowed), OnError="skip" drops its documents instead of aborting the
+// run.
+func TestRunOnErrorSkipDropsUnrecoverableDocuments(t *testing.T) {
+	f := New([]string{"sh", "-c", "exit 1"})
+	f.RestartLimit = 0
+	f.OnError = "skip"
+
+	input := [][]byte{[]byte("hello\nthere"), []byte("world\nagain")}
+	got := runFiltered(t, f, input)
+	if len(got) != 0 {
+		t.Fatalf("expected no output with OnError=skip, got %q", got)
+	}
+}
+
+// TestRunCrashWithDefaultOnErrorReturnsPromptly checks that a filter crash
+// under the CLI's own defaults (RestartLimit=0, OnError="fail") makes Run
+// return an error instead of wedging forever. The input is deeper than
+// f.Buffer so the dispatch loop would block sending to a full order
+// channel if nothing cancelled it once collect gave up on the worker.
+func TestRunCrashWithDefaultOnErrorReturnsPromptly(t *testing.T) {
+	f := New([]string{"sh", "-c", "exit 1"})
+
+	input := make([][]byte, f.Buffer+50)
+	for i := range input {
+		input[i] = []byte(fmt.Sprintf("doc%d", i))
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, doc := range input {
+			fmt.Fprintln(pw, base64.StdEncoding.EncodeToString(doc))
+		}
+		pw.Close()
+	}()
+
+	var out bytes.Buffer
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.Run(context.Background(), pr, &out) }()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("expected Run to return an error when the filter crashes with OnError=fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return promptly after a filter crash")
+	}
+}
+
+// TestRunParallelPreservesOrder shards documents across several worker
+// processes whose completion order is deliberately scrambled (each worker
+// sleeps for however long its own documents tell it to) and checks that
+// output is still written in the order documents were read, not the order
+// workers finish.
+func TestRunParallelPreservesOrder(t *testing.T) {
+	f := New([]string{"sh", "-c", `while IFS= read -r line; do sleep "0.$line"; echo "$line"; done`})
+	f.Parallelism = 2
+
+	var input [][]byte
+	for i := 0; i < 4; i++ {
+		// Round-robin sharding puts every "3" on worker 0 and every "0" on
+		// worker 1, so worker 0 is consistently slower.
+		input = append(input, []byte("3"), []byte("0"))
+	}
+
+	got := runFiltered(t, f, input)
+	if len(got) != len(input) {
+		t.Fatalf("got %d documents, want %d", len(got), len(input))
+	}
+	for i, doc := range input {
+		if !bytes.Equal(got[i], doc) {
+			t.Errorf("document %d: got %q, want %q", i, got[i], doc)
+		}
+	}
+}
+
+// TestRunLengthFraming checks the length-prefixed framing mode round-trips
+// arbitrary bytes, including embedded newlines, through an io.Pipe.
+func TestRunLengthFraming(t *testing.T) {
+	f := New([]string{"cat"})
+	f.Framing = "length"
+
+	input := [][]byte{[]byte("hello"), []byte("multi\nline\nbody"), {}}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, doc := range input {
+			writeFrame(pw, doc)
+		}
+		pw.Close()
+	}()
+
+	var out bytes.Buffer
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.Run(context.Background(), pr, &out) }()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not finish")
+	}
+
+	r := bytes.NewReader(out.Bytes())
+	var got [][]byte
+	for {
+		doc, err := readFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading output frame: %v", err)
+		}
+		got = append(got, doc)
+	}
+
+	if len(got) != len(input) {
+		t.Fatalf("got %d documents, want %d", len(got), len(input))
+	}
+	for i, doc := range input {
+		if !bytes.Equal(got[i], doc) {
+			t.Errorf("document %d: got %q, want %q", i, got[i], doc)
+		}
+	}
+}
+
+// TestRunCodecs checks that -input-codec/-output-codec transparently wrap
+// the document stream: the filter itself only ever sees decompressed
+// base64 lines.
+func TestRunCodecs(t *testing.T) {
+	f := New([]string{"cat"})
+	f.InputCodec = "gzip"
+	f.OutputCodec = "gzip"
+
+	input := [][]byte{[]byte("hello"), []byte("world")}
+
+	pr, pw := io.Pipe()
+	go func() {
+		gw := gzip.NewWriter(pw)
+		for _, doc := range input {
+			fmt.Fprintln(gw, base64.StdEncoding.EncodeToString(doc))
+		}
+		gw.Close()
+		pw.Close()
+	}()
+
+	var out bytes.Buffer
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.Run(context.Background(), pr, &out) }()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not finish")
+	}
+
+	gr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("decompressing output: %v", err)
+	}
+	decompressed, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading decompressed output: %v", err)
+	}
+
+	got := decodeOutput(t, decompressed)
+	if len(got) != len(input) {
+		t.Fatalf("got %d documents, want %d", len(got), len(input))
+	}
+	for i, doc := range input {
+		if !bytes.Equal(got[i], doc) {
+			t.Errorf("document %d: got %q, want %q", i, got[i], doc)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Command b64filter runs a program as a filter over base64-encoded (or
+// length-framed) documents read from stdin, writing the filtered results
+// to stdout. See the b64filter package for the underlying implementation.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/paracrawl/b64filter"
+)
+
+func main() {
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+
+	f := b64filter.New(nil)
+	flag.BoolVar(&f.Debug, "d", false, "Debugging output")
+	flag.IntVar(&f.Progress, "p", 100, "Report progress every p documents")
+	flag.IntVar(&f.Parallelism, "j", 1, "Number of parallel filter processes")
+	flag.StringVar(&f.InputCodec, "input-codec", "", "Decompress stdin with this codec: gzip, zstd, brotli")
+	flag.StringVar(&f.OutputCodec, "output-codec", "", "Compress stdout with this codec: gzip, zstd, brotli")
+	flag.StringVar(&f.Framing, "framing", "base64", "Document framing: base64 or length")
+	flag.IntVar(&f.Buffer, "buffer", 32, "Maximum documents in flight between reader and collector")
+	flag.IntVar(&f.RestartLimit, "restart", 0, "Times to restart a crashed filter process, re-feeding in-flight documents")
+	flag.StringVar(&f.OnError, "on-error", "fail", "What to do with a document an exhausted worker can't process: fail, skip, passthrough")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s filter [args]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(),
+`
+Runs the given program as a filter on the input. Standard input and output are
+expected to be base 64 encoded, one document or record per line (or, with
+-framing=length, a 4-byte big-endian length prefix followed by the raw
+document). The input is passed in decoded form through the filter program,
+and then re-encoded.
+
+Example:
+
+    $ < test b64filter cat > test.cat
+    2020/02/16 12:15:29 main.go:64: processed 2 documents
+    $ diff test test.cat
+    $
+
+With -framing=base64 (the default) the filter program must produce exactly
+one line of output per line of input. With -j, that many copies of the
+filter are run in parallel, each fed its own share of the documents;
+output is still written in the original order.
+`)
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(-1)
+	}
+	f.Args = flag.Args()
+
+	if err := f.Run(context.Background(), os.Stdin, os.Stdout); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("processed %v documents", f.Processed)
+}
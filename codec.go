@@ -0,0 +1,57 @@
+package b64filter
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// wrapReader wraps r in a decompressing reader for f.InputCodec. An empty
+// codec returns r unchanged.
+func (f *Filter) wrapReader(r io.Reader) (io.Reader, error) {
+	switch f.InputCodec {
+	case "":
+		return r, nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		// Decoder.Close doesn't return an error, so wrap it as an
+		// io.ReadCloser to let Run release it like any other codec.
+		return d.IOReadCloser(), nil
+	case "brotli":
+		return brotli.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown input codec %q", f.InputCodec)
+	}
+}
+
+// wrapWriter wraps w in a compressing writer for f.OutputCodec. The
+// returned writer must be closed to flush the final frame; an empty codec
+// returns w wrapped in a no-op closer.
+func (f *Filter) wrapWriter(w io.Writer) (io.WriteCloser, error) {
+	switch f.OutputCodec {
+	case "":
+		return nopCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "brotli":
+		return brotli.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown output codec %q", f.OutputCodec)
+	}
+}
@@ -0,0 +1,68 @@
+package b64filter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// collect reads one result per entry from order, in order, retrying on a
+// worker's configured restart policy and applying f.OnError once a
+// worker's restarts are exhausted, then writes the result to w. It returns
+// the number of documents written, once order is closed and drained, or
+// ctx is cancelled.
+func (f *Filter) collect(ctx context.Context, workers []*worker, order <-chan orderEntry, w io.Writer) (int, error) {
+	ndocs := 0
+	nlines := 0
+	start := time.Now()
+
+	for {
+		var ord orderEntry
+		var ok bool
+		select {
+		case ord, ok = <-order:
+			if !ok {
+				return ndocs, nil
+			}
+		case <-ctx.Done():
+			return ndocs, ctx.Err()
+		}
+
+		wrk := workers[ord.worker]
+		pending := wrk.peekPending()
+
+		result, err := readResult(wrk, f.Framing, pending.doc)
+		for err != nil && !wrk.isDead() {
+			if f.Debug {
+				log.Printf("collect: worker %d errored, restarting: %v", ord.worker, err)
+			}
+			wrk.restart()
+			result, err = readResult(wrk, f.Framing, pending.doc)
+		}
+		if err != nil {
+			switch f.OnError {
+			case "skip":
+				wrk.popPending()
+				continue
+			case "passthrough":
+				result = pending.doc
+			default:
+				return ndocs, fmt.Errorf("collect: worker %d: %w", ord.worker, err)
+			}
+		}
+		wrk.popPending()
+
+		if err := emit(w, f.Framing, result); err != nil {
+			return ndocs, fmt.Errorf("collect: writing result: %w", err)
+		}
+
+		ndocs++
+		nlines += bytes.Count(pending.doc, []byte("\n")) + 1
+		if f.Progress > 0 && ndocs%f.Progress == 0 {
+			log.Printf("collect: written %d docs, %d lines in %s", ndocs, nlines, time.Since(start))
+		}
+	}
+}
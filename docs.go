@@ -0,0 +1,128 @@
+package b64filter
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+)
+
+// readDocs decodes documents from r in f.Framing and streams them on the
+// returned channel, which is closed once r is exhausted or ctx is
+// cancelled. The error channel receives at most one value: nil on a clean
+// EOF, or the error that stopped decoding.
+func (f *Filter) readDocs(ctx context.Context, r io.Reader) (<-chan []byte, <-chan error) {
+	ch := make(chan []byte)
+	errCh := make(chan error, 1)
+	if f.Framing == "length" {
+		go f.readLengthFramedDocs(ctx, r, ch, errCh)
+		return ch, errCh
+	}
+	go f.readBase64Docs(ctx, r, ch, errCh)
+	return ch, errCh
+}
+
+func (f *Filter) readLengthFramedDocs(ctx context.Context, r io.Reader, ch chan []byte, errCh chan error) {
+	defer close(ch)
+	for {
+		doc, err := readFrame(r)
+		if err != nil {
+			if err == io.EOF {
+				errCh <- nil
+			} else {
+				errCh <- err
+			}
+			return
+		}
+		select {
+		case ch <- doc:
+		case <-ctx.Done():
+			errCh <- ctx.Err()
+			return
+		}
+	}
+}
+
+func (f *Filter) readBase64Docs(ctx context.Context, r io.Reader, ch chan []byte, errCh chan error) {
+	defer close(ch)
+	buf := bufio.NewReader(r)
+
+	send := func(b []byte) bool {
+		select {
+		case ch <- b:
+			return true
+		case <-ctx.Done():
+			errCh <- ctx.Err()
+			return false
+		}
+	}
+
+	line := make([]byte, 0, 1024)
+	for {
+		chunk, pfx, err := buf.ReadLine()
+		if len(chunk) > 0 {
+			line = append(line, chunk...)
+		}
+		if err != nil {
+			if err == io.EOF {
+				if len(line) > 0 {
+					b := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+					n, derr := base64.StdEncoding.Decode(b, line)
+					if derr != nil {
+						errCh <- derr
+						return
+					}
+					if !send(b[:n]) {
+						return
+					}
+				}
+				errCh <- nil
+			} else {
+				errCh <- err
+			}
+			return
+		}
+		if !pfx {
+			b := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+			n, derr := base64.StdEncoding.Decode(b, line)
+			if derr != nil {
+				errCh <- derr
+				return
+			}
+			if !send(b[:n]) {
+				return
+			}
+			line = make([]byte, 0, 1024)
+		}
+	}
+}
+
+// readNLines reads up to count newline-delimited lines from buf. A
+// premature EOF stops the read early, so fewer than count lines are
+// returned; a non-empty partial line read before the EOF is kept as the
+// last one.
+func readNLines(count int, buf *bufio.Reader) (lines [][]byte, err error) {
+	lines = make([][]byte, 0, count)
+
+	line := make([]byte, 0, 1024)
+	for n := 0; n < count; n++ {
+		chunk, pfx, err := buf.ReadLine()
+		line = append(line, chunk...)
+		if err != nil {
+			if err == io.EOF {
+				if len(line) > 0 {
+					lines = append(lines, line)
+				}
+				break
+			}
+			return nil, err
+		}
+		if !pfx {
+			lines = append(lines, line)
+			line = make([]byte, 0, 1024)
+		} else { // don't have a complete line, loop again
+			n--
+		}
+	}
+	return
+}
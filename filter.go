@@ -0,0 +1,214 @@
+// Package b64filter runs an external program as a line-oriented document
+// filter: stdin and stdout are framed as one document per line (base64
+// encoded) or as length-prefixed messages, so the filter program itself
+// only has to transform one document to another.
+package b64filter
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Filter describes how to run a filter program over a stream of documents.
+// The zero value is not ready to use; construct one with New.
+type Filter struct {
+	// Args is the filter program and its arguments, e.g. []string{"cat"}.
+	Args []string
+
+	// Parallelism is the number of copies of the filter program to run
+	// concurrently, sharding documents across them round-robin. Output is
+	// still emitted in the order documents were read.
+	Parallelism int
+
+	// InputCodec and OutputCodec transparently decompress/compress the
+	// streams passed to Run: "", "gzip", "zstd", or "brotli".
+	InputCodec  string
+	OutputCodec string
+
+	// Framing selects how documents are delimited: "base64" (one base64
+	// line per document, the default) or "length" (a 4-byte big-endian
+	// length prefix, which allows the filter to emit arbitrary bytes).
+	Framing string
+
+	// Buffer caps the number of documents that may be in flight between
+	// the reader and the collector at once.
+	Buffer int
+
+	// RestartLimit is the number of times a worker's filter process may be
+	// restarted after it crashes, re-feeding the documents that were in
+	// flight when it died.
+	RestartLimit int
+
+	// OnError decides what happens to a document whose worker has
+	// exhausted its restarts: "fail" (the default), "skip", or
+	// "passthrough".
+	OnError string
+
+	// Progress logs a line every Progress documents written; 0 disables
+	// progress logging.
+	Progress int
+
+	// Debug logs each worker error that makes collect restart the worker.
+	Debug bool
+
+	// Processed is the number of documents processed by the most recent
+	// call to Run.
+	Processed int
+}
+
+// New returns a Filter configured to run args with this package's default
+// settings.
+func New(args []string) *Filter {
+	return &Filter{
+		Args:        args,
+		Parallelism: 1,
+		Framing:     "base64",
+		Buffer:      32,
+		OnError:     "fail",
+		Progress:    100,
+	}
+}
+
+func (f *Filter) validate() error {
+	if len(f.Args) == 0 {
+		return fmt.Errorf("b64filter: no filter program given")
+	}
+	if f.Parallelism < 1 {
+		return fmt.Errorf("b64filter: Parallelism must be at least 1")
+	}
+	if f.Framing != "base64" && f.Framing != "length" {
+		return fmt.Errorf("b64filter: Framing must be one of base64, length")
+	}
+	if f.Buffer < 1 {
+		return fmt.Errorf("b64filter: Buffer must be at least 1")
+	}
+	if f.RestartLimit < 0 {
+		return fmt.Errorf("b64filter: RestartLimit must be at least 0")
+	}
+	switch f.OnError {
+	case "fail", "skip", "passthrough":
+	default:
+		return fmt.Errorf("b64filter: OnError must be one of fail, skip, passthrough")
+	}
+	return nil
+}
+
+// Run filters documents read from in, writing the results to out, until in
+// is exhausted or ctx is cancelled. It starts and supervises Parallelism
+// copies of f.Args for the duration of the call.
+func (f *Filter) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := make([]*worker, f.Parallelism)
+	for i := range workers {
+		wrk, err := f.startWorker()
+		if err != nil {
+			return fmt.Errorf("b64filter: starting worker %d: %w", i, err)
+		}
+		workers[i] = wrk
+	}
+	defer func() {
+		for _, wrk := range workers {
+			if wrk.cmd.Process != nil {
+				wrk.cmd.Process.Kill()
+			}
+		}
+	}()
+
+	wout, err := f.wrapWriter(out)
+	if err != nil {
+		return fmt.Errorf("b64filter: opening output: %w", err)
+	}
+	win, err := f.wrapReader(in)
+	if err != nil {
+		return fmt.Errorf("b64filter: opening input: %w", err)
+	}
+
+	order := make(chan orderEntry, f.Buffer)
+	collectErrCh := make(chan error, 1)
+	go func() {
+		n, err := f.collect(runCtx, workers, order, wout)
+		f.Processed = n
+		if err != nil {
+			// Unblock the dispatch loop below: with nobody left to drain
+			// order, it would otherwise wedge forever once f.Buffer fills
+			// up, and Run would never return.
+			cancel()
+		}
+		collectErrCh <- err
+	}()
+
+	docs, readErrCh := f.readDocs(runCtx, win)
+	i := 0
+dispatch:
+	for {
+		select {
+		case doc, ok := <-docs:
+			if !ok {
+				break dispatch
+			}
+			wi := i % f.Parallelism
+			wrk := workers[wi]
+			wrk.appendPending(doc)
+			if !wrk.isDead() {
+				// A write failure means the filter process died between
+				// our last isDead check and this write; restart folds the
+				// just-appended doc into its pending replay, so there's
+				// nothing left to write here. If restart can't recover it,
+				// the worker is left dead and collect applies f.OnError
+				// when it gets to this document.
+				if err := writeDoc(wrk, f.Framing, doc); err != nil {
+					wrk.restart()
+				}
+			}
+			select {
+			case order <- orderEntry{worker: wi}:
+			case <-runCtx.Done():
+				break dispatch
+			}
+			i++
+		case <-runCtx.Done():
+			break dispatch
+		}
+	}
+
+	for _, wrk := range workers {
+		wrk.Close()
+	}
+	close(order)
+
+	runErr := <-collectErrCh
+	for wi, wrk := range workers {
+		if wrk.isDead() {
+			// restart already reaped this worker's last process
+			continue
+		}
+		if err := wrk.cmd.Wait(); err != nil && runErr == nil {
+			runErr = fmt.Errorf("b64filter: worker %d: %w", wi, err)
+		}
+	}
+	if err := wout.Close(); err != nil && runErr == nil {
+		runErr = fmt.Errorf("b64filter: closing output: %w", err)
+	}
+	// wrapReader's zstd case in particular holds background goroutines and
+	// buffers that are only released by Close; Run is meant to be called
+	// repeatedly by long-lived processes, so leaking one per call matters.
+	if c, ok := win.(io.Closer); ok {
+		if err := c.Close(); err != nil && runErr == nil {
+			runErr = fmt.Errorf("b64filter: closing input: %w", err)
+		}
+	}
+	if readErr := <-readErrCh; readErr != nil && runErr == nil {
+		runErr = readErr
+	}
+	if runErr == nil {
+		runErr = ctx.Err()
+	}
+	return runErr
+}
@@ -0,0 +1,36 @@
+package b64filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// readFrame reads one length-prefixed frame: a 4-byte big-endian length
+// followed by that many bytes of document content.
+func readFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("readFrame: truncated length prefix: %v", err)
+		}
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	doc := make([]byte, n)
+	if _, err := io.ReadFull(r, doc); err != nil {
+		return nil, fmt.Errorf("readFrame: truncated frame body: %v", err)
+	}
+	return doc, nil
+}
+
+// writeFrame writes one length-prefixed frame to w.
+func writeFrame(w io.Writer, doc []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(doc)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(doc)
+	return err
+}
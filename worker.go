@@ -0,0 +1,237 @@
+package b64filter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// pendingDoc is a document that has been sent to a worker but whose result
+// has not yet been read back.
+type pendingDoc struct {
+	doc []byte
+}
+
+// orderEntry records which worker a dispatched document was sent to, so the
+// collector can read results back in the order they were dispatched.
+type orderEntry struct {
+	worker int
+}
+
+// worker supervises one copy of the filter program. stdin, stdout, and cmd
+// are swapped out wholesale by restart, so any access to them must hold mu.
+type worker struct {
+	f *Filter
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	pending  []pendingDoc
+	dead     bool
+	closed   bool // Close has been called; no more documents are coming
+	restarts int
+}
+
+// startWorker starts one copy of f.Args, wiring its stderr to this
+// process's stderr and returning a worker ready to have documents written
+// to it.
+func (f *Filter) startWorker() (*worker, error) {
+	cmd := exec.Command(f.Args[0], f.Args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("b64filter: stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("b64filter: stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("b64filter: stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("b64filter: starting %v: %w", f.Args, err)
+	}
+	go io.Copy(os.Stderr, stderr)
+
+	return &worker{
+		f:      f,
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// Write writes p to the worker's current stdin, guarding against a
+// concurrent restart swapping it out from under us.
+func (w *worker) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	stdin := w.stdin
+	w.mu.Unlock()
+	return stdin.Write(p)
+}
+
+// Close closes the worker's current stdin and records that no further
+// documents will be written, so that a later restart knows to close
+// whatever stdin it spawns too.
+func (w *worker) Close() error {
+	w.mu.Lock()
+	stdin := w.stdin
+	w.closed = true
+	w.mu.Unlock()
+	return stdin.Close()
+}
+
+// currentStdout returns the worker's current stdout reader, guarding
+// against a concurrent restart swapping it out from under us.
+func (w *worker) currentStdout() *bufio.Reader {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stdout
+}
+
+func (w *worker) appendPending(doc []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, pendingDoc{doc: doc})
+}
+
+func (w *worker) peekPending() pendingDoc {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending[0]
+}
+
+func (w *worker) popPending() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = w.pending[1:]
+}
+
+func (w *worker) isDead() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dead
+}
+
+// restart kills the worker's current process, reaps it, and - unless the
+// worker has already been restarted f.RestartLimit times - starts a fresh
+// copy of the filter program and re-feeds it every document still pending.
+// It reports whether the worker is still alive afterward.
+func (w *worker) restart() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cmd.Process != nil {
+		w.cmd.Process.Kill()
+	}
+	w.cmd.Wait()
+
+	if w.restarts >= w.f.RestartLimit {
+		w.dead = true
+		return false
+	}
+	w.restarts++
+
+	cmd := exec.Command(w.f.Args[0], w.f.Args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		w.dead = true
+		return false
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		w.dead = true
+		return false
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		w.dead = true
+		return false
+	}
+	if err := cmd.Start(); err != nil {
+		w.dead = true
+		return false
+	}
+	go io.Copy(os.Stderr, stderr)
+
+	w.cmd = cmd
+	w.stdin = stdin
+	w.stdout = bufio.NewReader(stdout)
+
+	for _, p := range w.pending {
+		// writeDoc acquires w.mu itself via Write, so write to the raw
+		// stdin here: restart already holds the lock.
+		if err := writeDocTo(w.stdin, w.f.Framing, p.doc); err != nil {
+			w.cmd.Process.Kill()
+			w.cmd.Wait()
+			w.dead = true
+			return false
+		}
+	}
+
+	// If Close already ran against the previous process, Run's dispatch
+	// loop is done feeding this worker and won't call Close again; close
+	// the freshly spawned stdin ourselves so the restarted filter still
+	// sees EOF once it drains the replayed pending docs.
+	if w.closed {
+		w.stdin.Close()
+	}
+	return true
+}
+
+// writeDoc feeds doc to the filter program per framing: the length-framed
+// protocol writes doc verbatim behind a length prefix, while the base64
+// protocol writes doc's raw bytes followed by a newline, relying on the
+// filter to echo back the same number of lines.
+func writeDoc(w *worker, framing string, doc []byte) error {
+	return writeDocTo(w, framing, doc)
+}
+
+func writeDocTo(w io.Writer, framing string, doc []byte) error {
+	if framing == "length" {
+		return writeFrame(w, doc)
+	}
+	if _, err := w.Write(doc); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
+// readResult reads doc's filtered result back from wrk.
+func readResult(wrk *worker, framing string, doc []byte) ([]byte, error) {
+	stdout := wrk.currentStdout()
+	if framing == "length" {
+		return readFrame(stdout)
+	}
+	n := bytes.Count(doc, []byte("\n")) + 1
+	lines, err := readNLines(n, stdout)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) != n {
+		return nil, fmt.Errorf("short read: got %d lines, want %d", len(lines), n)
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
+
+// emit writes doc's result to w per framing: length-framed output is
+// written verbatim, base64 output is re-encoded as a single line.
+func emit(w io.Writer, framing string, doc []byte) error {
+	if framing == "length" {
+		return writeFrame(w, doc)
+	}
+	elen := base64.StdEncoding.EncodedLen(len(doc))
+	b := make([]byte, elen, elen+1)
+	base64.StdEncoding.Encode(b, doc)
+	b = append(b, '\n')
+	_, err := w.Write(b)
+	return err
+}